@@ -2,6 +2,7 @@
 package errs
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +14,47 @@ import (
 // valid.
 type Namer interface{ Name() (string, bool) }
 
+// defaultStackDepth and captureStack are the package-wide defaults used to
+// capture stack traces. Capturing a stack is the dominant cost of this
+// package, so callers on hot paths can shrink or disable it with
+// SetStackDepth and SetCaptureStack, or override it per call site with
+// Tag.WithOptions.
+var (
+	defaultStackDepth = 64
+	captureStack      = true
+)
+
+// SetStackDepth sets the default maximum number of stack frames captured by
+// new errors. It does not affect errors already created.
+func SetStackDepth(n int) {
+	defaultStackDepth = n
+}
+
+// SetCaptureStack sets whether new errors capture a stack trace at all. It
+// does not affect errors already created.
+func SetCaptureStack(enabled bool) {
+	captureStack = enabled
+}
+
+// StackTrace returns the stack frames captured for err, from the point it
+// was first created, or nil if no stack was captured.
+func StackTrace(err error) []runtime.Frame {
+	pcs := firstPCs(err)
+	if len(pcs) == 0 {
+		return nil
+	}
+
+	var out []runtime.Frame
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		out = append(out, frame)
+		if !more {
+			return out
+		}
+	}
+}
+
 // Errorf does the same thing as fmt.Errorf(...) except it captures a stack
 // trace on creation.
 func Errorf(format string, args ...interface{}) error {
@@ -30,24 +72,178 @@ func Tagged(tag string, err error) error {
 	return Tag(tag).wrap(err)
 }
 
-// Tags returns all the tags that have wrapped the error.
-func Tags(err error) (tags []Tag) {
+// WithContext is a shorthand for Tag("").WithContext(ctx, err).
+func WithContext(ctx map[string]interface{}, err error) error {
+	return Tag("").withContext(ctx, err)
+}
+
+// Context returns the merged structured context attached to err and anything
+// it wraps. Context walks the chain with errors.Unwrap, and values attached
+// closer to the outside of the chain override values attached further in.
+func Context(err error) map[string]interface{} {
+	var chain []map[string]interface{}
+	for err != nil {
+		if e, ok := err.(*errorT); ok && len(e.ctx) > 0 {
+			chain = append(chain, e.ctx)
+		}
+		err = errors.Unwrap(err)
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+
+	merged := make(map[string]interface{})
+	for i := len(chain) - 1; i >= 0; i-- {
+		for k, v := range chain[i] {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// ToMap returns the merged structured Context for err along with the
+// location the error was first created, under the "go-func", "go-file" and
+// "go-line" keys. It is meant to be passed directly to a structured logger,
+// e.g. log.WithFields(errs.ToMap(err)).
+func ToMap(err error) map[string]interface{} {
+	ctx := Context(err)
+	if ctx == nil {
+		ctx = make(map[string]interface{})
+	}
+
+	if pcs := firstPCs(err); len(pcs) > 0 {
+		frame, _ := runtime.CallersFrames(pcs[:1]).Next()
+		ctx["go-func"] = frame.Function
+		ctx["go-file"] = frame.File
+		ctx["go-line"] = frame.Line
+	}
+
+	return ctx
+}
+
+// firstPCs returns the stack captured furthest out on the wrap chain, which
+// is where the error was originally created.
+func firstPCs(err error) []uintptr {
 	for {
 		e, ok := err.(*errorT)
 		if !ok {
-			return tags
+			return nil
 		}
-		if e.tag != "" {
-			tags = append(tags, e.tag)
+		if len(e.pcs) > 0 {
+			return e.pcs
 		}
 		err = errors.Unwrap(err)
 	}
 }
 
+// HasTag reports whether t appears anywhere in err's wrap chain, not just as
+// the outermost tag.
+func HasTag(err error, t Tag) bool {
+	for _, tag := range Tags(err) {
+		if tag == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Tags returns all the tags that have wrapped the error, recursing into the
+// branches of any Group encountered along the way.
+func Tags(err error) (tags []Tag) {
+	collectTags(err, &tags)
+	return tags
+}
+
+// collectTags walks err's wrap chain, descending into Group branches,
+// appending every tag it finds to tags.
+func collectTags(err error, tags *[]Tag) {
+	for {
+		if err == nil {
+			return
+		}
+		if e, ok := err.(*errorT); ok {
+			if e.tag != "" {
+				*tags = append(*tags, e.tag)
+			}
+			err = errors.Unwrap(err)
+			continue
+		}
+		if g, ok := err.(*Group); ok {
+			for _, child := range g.errs {
+				collectTags(child, tags)
+			}
+		}
+		return
+	}
+}
+
 //
 // error tags
 //
 
+// Combine merges errs into a single error implementing Unwrap() []error, so
+// that errors.Is and errors.As traverse every branch. Nil errors are
+// dropped; Combine returns nil if every error is nil, and returns the error
+// itself, unwrapped in a Group, if only one is non-nil.
+func Combine(errs ...error) error {
+	var filtered []error
+	for _, err := range errs {
+		if err != nil {
+			filtered = append(filtered, err)
+		}
+	}
+	switch len(filtered) {
+	case 0:
+		return nil
+	case 1:
+		return filtered[0]
+	default:
+		return &Group{errs: filtered}
+	}
+}
+
+// Group is the error returned by Combine: it aggregates several errors,
+// e.g. collected from concurrent workers or a validation pass, without
+// losing the tags or stack trace of any of them.
+type Group struct {
+	errs []error
+}
+
+var ( // ensure *Group implements the helper interfaces.
+	_ error = (*Group)(nil)
+)
+
+// Errors returns the errors that make up the group.
+func (g *Group) Errors() []error {
+	return g.errs
+}
+
+// Error implements the error interface.
+func (g *Group) Error() string {
+	return fmt.Sprintf("%v", g)
+}
+
+// Unwrap returns the errors that make up the group, per the go1.20
+// multi-error convention, so that errors.Is and errors.As check every one.
+func (g *Group) Unwrap() []error {
+	return g.errs
+}
+
+// Format handles the formatting of the group. Using a "+" on the format
+// string specifier will also write the stack trace of every child error.
+func (g *Group) Format(f fmt.State, c rune) {
+	for i, err := range g.errs {
+		if i > 0 {
+			fmt.Fprint(f, "\n")
+		}
+		if f.Flag(int('+')) {
+			fmt.Fprintf(f, "%+v", err)
+		} else {
+			fmt.Fprintf(f, "%v", err)
+		}
+	}
+}
+
 // Tag represents some extra information about an error.
 type Tag string
 
@@ -63,13 +259,151 @@ func (t Tag) Wrap(err error) error {
 	return t.wrap(err)
 }
 
+// WithContext is like Wrap, but additionally attaches the given structured
+// context to the error. The context can be read back out with Context or
+// ToMap, and is merged across the whole wrap chain, but it never changes
+// what Error() or Format() render.
+func (t Tag) WithContext(ctx map[string]interface{}, err error) error {
+	return t.withContext(ctx, err)
+}
+
+// withContext is the shared implementation behind Tag.WithContext and the
+// free WithContext function. Both call it directly, rather than one
+// routing through the other, so that the stack it captures always starts
+// at their shared caller instead of at whichever of the two is outermost.
+func (t Tag) withContext(ctx map[string]interface{}, err error) error {
+	e := t.wrapWith(err, options{}, 4)
+	if e == nil {
+		return nil
+	}
+
+	et := e.(*errorT)
+	if et == err {
+		// wrap collapsed into the existing error (same tag, or no tag at
+		// all): copy it so we don't mutate an error the caller still holds
+		// a reference to.
+		cp := *et
+		et = &cp
+	}
+
+	merged := make(map[string]interface{}, len(et.ctx)+len(ctx))
+	for k, v := range et.ctx {
+		merged[k] = v
+	}
+	for k, v := range ctx {
+		merged[k] = v
+	}
+	et.ctx = merged
+
+	return et
+}
+
+// Combine is a shorthand for Tag(tag).Wrap(Combine(errs...)).
+func (t Tag) Combine(errs ...error) error {
+	return t.wrap(Combine(errs...))
+}
+
 // Error returns the class string as the error text. It allows the use of
 // errors.Is, or as just an easy way to have a string constant error.
 func (t Tag) Error() string { return string(t) }
 
+// Is is a shorthand for errors.Is(err, t).
+func (t Tag) Is(err error) bool {
+	return errors.Is(err, t)
+}
+
+// httpStatuses associates tags with an HTTP status code, via
+// Tag.WithHTTPStatus. It is meant to be populated once, at package init
+// time, alongside the declaration of each Tag.
+var httpStatuses = map[Tag]int{}
+
+// WithHTTPStatus associates code with t as the HTTP status that represents
+// it, for use by HTTPStatus and in the JSON encoding of tagged errors. It
+// returns t, for convenient use at declaration time, e.g.
+//
+//	var NotFound = errs.Tag("not found").WithHTTPStatus(http.StatusNotFound)
+func (t Tag) WithHTTPStatus(code int) Tag {
+	httpStatuses[t] = code
+	return t
+}
+
+// HTTPStatus returns the HTTP status code associated, with Tag.WithHTTPStatus,
+// with the first tag on err's wrap chain that has one, or, failing that, the
+// status carried by an error reconstructed with Unmarshal whose tag isn't
+// registered in this process. It returns 0 if neither is found.
+func HTTPStatus(err error) int {
+	for {
+		if err == nil {
+			return 0
+		}
+		e, ok := err.(*errorT)
+		if !ok {
+			if g, ok := err.(*Group); ok {
+				for _, child := range g.errs {
+					if status := HTTPStatus(child); status != 0 {
+						return status
+					}
+				}
+			}
+			return 0
+		}
+		if status := nodeHTTPStatus(e); status != 0 {
+			return status
+		}
+		err = errors.Unwrap(err)
+	}
+}
+
+// nodeHTTPStatus returns the HTTP status for e's own tag, preferring the
+// registry populated by Tag.WithHTTPStatus, and falling back to the status
+// carried on e itself by Unmarshal.
+func nodeHTTPStatus(e *errorT) int {
+	if e.tag != "" {
+		if status, ok := httpStatuses[e.tag]; ok {
+			return status
+		}
+	}
+	return e.httpStatus
+}
+
+// WithOptions returns a builder bound to this tag that captures stacks
+// according to opts instead of the package defaults, for overriding stack
+// capture on a per call site basis.
+func (t Tag) WithOptions(opts ...Option) TagOptions {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return TagOptions{tag: t, opts: o}
+}
+
+// TagOptions is a Tag bound to a set of stack capture Options, constructed
+// with Tag.WithOptions.
+type TagOptions struct {
+	tag  Tag
+	opts options
+}
+
+// Errorf is the TagOptions equivalent of Tag.Errorf.
+func (to TagOptions) Errorf(format string, args ...interface{}) error {
+	return to.tag.wrapWith(fmt.Errorf(format, args...), to.opts, 3)
+}
+
+// Wrap is the TagOptions equivalent of Tag.Wrap.
+func (to TagOptions) Wrap(err error) error {
+	return to.tag.wrapWith(err, to.opts, 3)
+}
+
 // create constructs the error, or just adds the class to the error, keeping
 // track of the stack if it needs to construct it.
 func (t Tag) wrap(err error) error {
+	return t.wrapWith(err, options{}, 4)
+}
+
+// wrapWith is the shared implementation behind wrap and TagOptions, taking
+// the stack capture options to use and the number of frames to skip to
+// reach the code that asked for the error to be wrapped.
+func (t Tag) wrapWith(err error, o options, skip int) error {
 	if err == nil {
 		return nil
 	}
@@ -88,15 +422,46 @@ func (t Tag) wrap(err error) error {
 		pcs: pcs,
 	}
 
-	if e.pcs == nil {
-		e.pcs = make([]uintptr, 64)
-		n := runtime.Callers(3, e.pcs)
+	if e.pcs == nil && captureStack && !o.noStack {
+		depth := o.depth
+		if depth == 0 {
+			depth = defaultStackDepth
+		}
+		e.pcs = make([]uintptr, depth)
+		n := runtime.Callers(skip+o.skip, e.pcs)
 		e.pcs = e.pcs[:n:n]
 	}
 
 	return e
 }
 
+// Option configures stack capture for Tag.WithOptions.
+type Option func(*options)
+
+// options holds the stack capture settings built up by a list of Options.
+type options struct {
+	depth   int
+	skip    int
+	noStack bool
+}
+
+// WithStackDepth overrides the default maximum number of stack frames
+// captured by the error.
+func WithStackDepth(n int) Option {
+	return func(o *options) { o.depth = n }
+}
+
+// WithSkip adds n extra frames to skip when capturing the stack, for
+// callers that wrap errs from inside their own helper functions.
+func WithSkip(n int) Option {
+	return func(o *options) { o.skip = n }
+}
+
+// WithoutStack disables stack capture for the error entirely.
+func WithoutStack() Option {
+	return func(o *options) { o.noStack = true }
+}
+
 //
 // errors
 //
@@ -106,6 +471,15 @@ type errorT struct {
 	tag Tag
 	err error
 	pcs []uintptr
+	ctx map[string]interface{}
+
+	// frames holds a stack trace as plain data rather than live program
+	// counters, for errors reconstructed from JSON by Unmarshal.
+	frames []Frame
+
+	// httpStatus carries the HTTP status read back by Unmarshal, for tags
+	// that have no Tag.WithHTTPStatus registration in this process.
+	httpStatus int
 }
 
 var ( // ensure *errorT implements the helper interfaces.
@@ -130,7 +504,11 @@ func (e *errorT) Format(f fmt.State, c rune) {
 		fmt.Fprintf(f, "%s%v", sep, text)
 	}
 	if f.Flag(int('+')) {
-		summarizeStack(f, e.pcs)
+		if len(e.pcs) > 0 {
+			summarizeStack(f, e.pcs)
+		} else {
+			summarizeFrames(f, e.frames)
+		}
 	}
 }
 
@@ -153,10 +531,19 @@ func (e *errorT) Name() (string, bool) {
 }
 
 // Is is for go1.13 errors so that the Is function reports true if the error is
-// part of the class.
+// part of the class, and otherwise delegates to the wrapped error so that
+// errors.Is(err, io.EOF) traverses tags to reach the underlying cause.
 func (e *errorT) Is(target error) bool {
-	tag, ok := target.(Tag)
-	return ok && e.tag == tag
+	if tag, ok := target.(Tag); ok {
+		return e.tag == tag
+	}
+	return errors.Is(e.err, target)
+}
+
+// As delegates to the wrapped error so that errors.As can extract a
+// concrete type through tagged wrappers.
+func (e *errorT) As(target interface{}) bool {
+	return errors.As(e.err, target)
 }
 
 // summarizeStack writes stack line entries to the writer.
@@ -170,3 +557,222 @@ func summarizeStack(w io.Writer, pcs []uintptr) {
 		fmt.Fprintf(w, "\n\t%s:%d", frame.Function, frame.Line)
 	}
 }
+
+// summarizeFrames writes stack line entries recovered from JSON to the
+// writer, the same way summarizeStack does for a live stack.
+func summarizeFrames(w io.Writer, frames []Frame) {
+	for _, frame := range frames {
+		fmt.Fprintf(w, "\n\t%s:%d", frame.Func, frame.Line)
+	}
+}
+
+//
+// json
+//
+
+// Frame is a single stack trace entry recovered from JSON by Unmarshal, as
+// opposed to a live runtime.Frame backed by a program counter.
+type Frame struct {
+	Func string
+	File string
+	Line int
+}
+
+// errorJSON is the wire format produced by MarshalJSON and Marshal, and
+// consumed by Unmarshal. A Group is encoded with Children set and
+// everything else empty; an *errorT is encoded with everything else and
+// Children left nil.
+type errorJSON struct {
+	Tags       []string     `json:"tags,omitempty"`
+	Message    string       `json:"message,omitempty"`
+	Stack      []frameJSON  `json:"stack,omitempty"`
+	Cause      *errorJSON   `json:"cause,omitempty"`
+	HTTPStatus int          `json:"http_status,omitempty"`
+	Children   []*errorJSON `json:"children,omitempty"`
+}
+
+// frameJSON is the wire format of a single Frame.
+type frameJSON struct {
+	Func string `json:"func"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// MarshalJSON implements json.Marshaler, recursively encoding e's tag,
+// message, stack trace, HTTP status and cause down the wrap chain.
+func (e *errorT) MarshalJSON() ([]byte, error) {
+	return json.Marshal(errToJSON(e))
+}
+
+// Marshal encodes err as JSON, recursively down its wrap chain, in the same
+// format as errorT.MarshalJSON. Marshal accepts any error, not just ones
+// from this package.
+func Marshal(err error) ([]byte, error) {
+	return json.Marshal(errToJSON(err))
+}
+
+// errToJSON converts err into its wire representation. A *Group is encoded
+// as its children, each recursed into in turn. An *errorT has its tag,
+// stack and HTTP status recorded on the result, with its cause recursed
+// into if it is itself an *errorT or *Group; otherwise its Error() text is
+// recorded as the message, and recursion stops. Re-tagging reuses the
+// original stack rather than capturing a new one, so a cause that shares
+// its stack with its wrapper has its Stack field omitted on the wire;
+// jsonToErr restores it from the wrapper on the way back in.
+func errToJSON(err error) *errorJSON {
+	return errToJSONFrom(err, nil)
+}
+
+// errToJSONFrom does the work of errToJSON, carrying along the immediate
+// wrapper (if any) so a shared stack can be recognized and omitted.
+func errToJSONFrom(err error, parent *errorT) *errorJSON {
+	if err == nil {
+		return nil
+	}
+
+	if g, ok := err.(*Group); ok {
+		out := &errorJSON{Children: make([]*errorJSON, len(g.errs))}
+		for i, child := range g.errs {
+			out.Children[i] = errToJSONFrom(child, nil)
+		}
+		return out
+	}
+
+	e, ok := err.(*errorT)
+	if !ok {
+		return &errorJSON{Message: err.Error()}
+	}
+
+	out := &errorJSON{}
+	if !sameStack(e, parent) {
+		out.Stack = framesToJSON(e)
+	}
+	if e.tag != "" {
+		out.Tags = []string{string(e.tag)}
+		out.HTTPStatus = nodeHTTPStatus(e)
+	}
+
+	switch e.err.(type) {
+	case *errorT, *Group:
+		out.Cause = errToJSONFrom(e.err, e)
+	default:
+		if e.err != nil {
+			out.Message = e.err.Error()
+		}
+	}
+
+	return out
+}
+
+// sameStack reports whether e's stack is the same one captured by parent,
+// i.e. backed by the same array, as happens when re-tagging an error
+// reuses its pcs instead of capturing fresh ones.
+func sameStack(e, parent *errorT) bool {
+	if parent == nil {
+		return false
+	}
+	if len(e.pcs) > 0 && len(e.pcs) == len(parent.pcs) {
+		return &e.pcs[0] == &parent.pcs[0]
+	}
+	if len(e.frames) > 0 && len(e.frames) == len(parent.frames) {
+		return &e.frames[0] == &parent.frames[0]
+	}
+	return false
+}
+
+// framesToJSON returns the wire representation of e's stack trace, using
+// its live pcs if it has one, or its decoded frames otherwise.
+func framesToJSON(e *errorT) []frameJSON {
+	if len(e.pcs) > 0 {
+		var out []frameJSON
+		frames := runtime.CallersFrames(e.pcs)
+		for {
+			frame, more := frames.Next()
+			out = append(out, frameJSON{Func: frame.Function, File: frame.File, Line: frame.Line})
+			if !more {
+				return out
+			}
+		}
+	}
+	if len(e.frames) == 0 {
+		return nil
+	}
+	out := make([]frameJSON, len(e.frames))
+	for i, frame := range e.frames {
+		out[i] = frameJSON{Func: frame.Func, File: frame.File, Line: frame.Line}
+	}
+	return out
+}
+
+// Unmarshal reconstructs an opaque error from JSON produced by Marshal or
+// errorT.MarshalJSON, preserving tags, stack frames and Group structure.
+// The frames are reconstructed as data, not live program counters, so
+// StackTrace will not find them, but they are still rendered by Format's
+// "%+v".
+func Unmarshal(data []byte) (error, error) {
+	if string(data) == "null" {
+		return nil, nil
+	}
+
+	var j errorJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+	return jsonToErr(&j), nil
+}
+
+// jsonToErr is the inverse of errToJSON.
+func jsonToErr(j *errorJSON) error {
+	return jsonToErrFrom(j, nil)
+}
+
+// jsonToErrFrom does the work of jsonToErr, threading down the frames
+// decoded for the nearest enclosing wrapper so that a node whose own
+// Stack was omitted, because errToJSON found it identical to its
+// wrapper's, ends up with the same frames again instead of none.
+func jsonToErrFrom(j *errorJSON, parentFrames []Frame) error {
+	if j == nil {
+		return nil
+	}
+
+	if j.Children != nil {
+		g := &Group{errs: make([]error, 0, len(j.Children))}
+		for _, child := range j.Children {
+			if childErr := jsonToErrFrom(child, nil); childErr != nil {
+				g.errs = append(g.errs, childErr)
+			}
+		}
+		return g
+	}
+
+	frames := parentFrames
+	if len(j.Stack) > 0 {
+		frames = make([]Frame, len(j.Stack))
+		for i, frame := range j.Stack {
+			frames[i] = Frame{Func: frame.Func, File: frame.File, Line: frame.Line}
+		}
+	}
+
+	var cause error
+	switch {
+	case j.Cause != nil:
+		cause = jsonToErrFrom(j.Cause, frames)
+	case j.Message != "":
+		cause = errors.New(j.Message)
+	}
+
+	if len(j.Tags) == 0 && len(j.Stack) == 0 && j.HTTPStatus == 0 {
+		return cause
+	}
+	if cause == nil {
+		// errorT always expects a non-nil wrapped error.
+		cause = errors.New("")
+	}
+
+	var tag Tag
+	if len(j.Tags) > 0 {
+		tag = Tag(j.Tags[0])
+	}
+
+	return &errorT{tag: tag, err: cause, frames: frames, httpStatus: j.HTTPStatus}
+}
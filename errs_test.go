@@ -1,11 +1,19 @@
 package errs
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"testing"
 )
 
+// pathError is a concrete error type, used to exercise errors.As through a
+// tagged wrapper.
+type pathError struct{}
+
+func (*pathError) Error() string { return "path error" }
+
 func TestErrs(t *testing.T) {
 	assert := func(t *testing.T, v bool, err ...interface{}) {
 		if !v {
@@ -14,56 +22,51 @@ func TestErrs(t *testing.T) {
 	}
 
 	var (
-		foo = Class("foo")
-		bar = Class("bar")
-		baz = Class("baz")
+		foo = Tag("foo")
+		bar = Tag("bar")
+		baz = Tag("baz")
 	)
 
-	t.Run("Class", func(t *testing.T) {
-		t.Run("Has", func(t *testing.T) {
-			assert(t, foo.Has(foo.New("t")))
-			assert(t, !foo.Has(bar.New("t")))
-			assert(t, !foo.Has(baz.New("t")))
-
-			assert(t, !bar.Has(foo.New("t")))
-			assert(t, bar.Has(bar.New("t")))
-			assert(t, !bar.Has(baz.New("t")))
+	t.Run("Tag", func(t *testing.T) {
+		t.Run("HasTag", func(t *testing.T) {
+			assert(t, HasTag(foo.Errorf("t"), foo))
+			assert(t, !HasTag(foo.Errorf("t"), bar))
+			assert(t, !HasTag(foo.Errorf("t"), baz))
 
-			assert(t, foo.Has(bar.Wrap(foo.New("t"))))
-			assert(t, bar.Has(bar.Wrap(foo.New("t"))))
-			assert(t, !baz.Has(bar.Wrap(foo.New("t"))))
+			assert(t, HasTag(bar.Wrap(foo.Errorf("t")), foo))
+			assert(t, HasTag(bar.Wrap(foo.Errorf("t")), bar))
+			assert(t, !HasTag(bar.Wrap(foo.Errorf("t")), baz))
 
-			assert(t, foo.Has(foo.Wrap(bar.New("t"))))
-			assert(t, bar.Has(foo.Wrap(bar.New("t"))))
-			assert(t, !baz.Has(foo.Wrap(bar.New("t"))))
+			assert(t, HasTag(foo.Wrap(bar.Errorf("t")), foo))
+			assert(t, HasTag(foo.Wrap(bar.Errorf("t")), bar))
+			assert(t, !HasTag(foo.Wrap(bar.Errorf("t")), baz))
 		})
 
 		t.Run("Same Name", func(t *testing.T) {
-			c1 := Class("c")
-			c2 := Class("c")
+			// Unlike an identity-based class, a Tag is just a string: two Tag
+			// values built from the same name are the same tag everywhere.
+			t1 := Tag("t")
+			t2 := Tag("t")
 
-			assert(t, c1.Has(c1.New("t")))
-			assert(t, !c2.Has(c1.New("t")))
-
-			assert(t, !c1.Has(c2.New("t")))
-			assert(t, c2.Has(c2.New("t")))
+			assert(t, t1 == t2)
+			assert(t, HasTag(t1.Errorf("x"), t2))
 		})
 	})
 
 	t.Run("Error", func(t *testing.T) {
-		t.Run("Format Contains Classes", func(t *testing.T) {
-			assert(t, strings.Contains(foo.New("t").Error(), "foo"))
-			assert(t, strings.Contains(bar.New("t").Error(), "bar"))
+		t.Run("Format Contains Tags", func(t *testing.T) {
+			assert(t, strings.Contains(foo.Errorf("t").Error(), "foo"))
+			assert(t, strings.Contains(bar.Errorf("t").Error(), "bar"))
 
-			assert(t, strings.Contains(bar.Wrap(foo.New("t")).Error(), "foo"))
-			assert(t, strings.Contains(bar.Wrap(foo.New("t")).Error(), "bar"))
+			assert(t, strings.Contains(bar.Wrap(foo.Errorf("t")).Error(), "foo"))
+			assert(t, strings.Contains(bar.Wrap(foo.Errorf("t")).Error(), "bar"))
 
-			assert(t, strings.Contains(foo.Wrap(bar.New("t")).Error(), "foo"))
-			assert(t, strings.Contains(foo.Wrap(bar.New("t")).Error(), "bar"))
+			assert(t, strings.Contains(foo.Wrap(bar.Errorf("t")).Error(), "foo"))
+			assert(t, strings.Contains(foo.Wrap(bar.Errorf("t")).Error(), "bar"))
 		})
 
 		t.Run("Format With Stack", func(t *testing.T) {
-			err := foo.New("t")
+			err := foo.Errorf("t")
 
 			assert(t,
 				!strings.Contains(fmt.Sprintf("%v", err), "\n"),
@@ -75,18 +78,174 @@ func TestErrs(t *testing.T) {
 			)
 		})
 
-		t.Run("Format Nil", func(t *testing.T) {
-			var err *Error
-			assert(t, fmt.Sprintf("%v", err) == "<nil>")
-		})
-
 		t.Run("Unwrap", func(t *testing.T) {
 			err := fmt.Errorf("t")
 
-			assert(t, nil == Unwrap(nil))
-			assert(t, err == Unwrap(err))
-			assert(t, err == Unwrap(foo.Wrap(err)))
-			assert(t, err == Unwrap(bar.Wrap(foo.Wrap(err))))
+			assert(t, nil == errors.Unwrap(Wrap(nil)))
+			assert(t, err == errors.Unwrap(foo.Wrap(err)))
+			assert(t, err == errors.Unwrap(errors.Unwrap(bar.Wrap(foo.Wrap(err)))))
+		})
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		err := bar.WithContext(map[string]interface{}{"a": 1, "b": 1},
+			foo.WithContext(map[string]interface{}{"b": 2, "c": 3}, foo.Errorf("t")))
+
+		ctx := Context(err)
+		assert(t, ctx["a"] == 1, "outer-only key missing")
+		assert(t, ctx["c"] == 3, "inner-only key missing")
+		assert(t, ctx["b"] == 1, "outer value should win over inner:", ctx["b"])
+
+		m := ToMap(err)
+		assert(t, m["go-func"] != nil && m["go-func"] != "", "ToMap missing go-func")
+		assert(t, m["go-file"] != nil && m["go-file"] != "", "ToMap missing go-file")
+		assert(t, m["a"] == 1)
+
+		// The free WithContext function is one layer of indirection removed
+		// from Tag.WithContext; ToMap must still report this call site, not
+		// the library's. Wrapping a plain (non-errorT) error forces a fresh
+		// stack capture here, rather than inheriting one from an inner tag.
+		free := ToMap(WithContext(map[string]interface{}{"a": 1}, errors.New("x")))
+		assert(t,
+			!strings.Contains(free["go-func"].(string), "errs.WithContext"),
+			"go-func should be the caller, not the free WithContext function:", free["go-func"],
+		)
+	})
+
+	t.Run("Is/As", func(t *testing.T) {
+		wrapped := foo.Wrap(fmt.Errorf("read failed: %w", io.EOF))
+
+		assert(t, errors.Is(wrapped, io.EOF), "errors.Is should traverse tags to reach io.EOF")
+		assert(t, errors.Is(wrapped, foo), "errors.Is should still match the tag itself")
+		assert(t, foo.Is(wrapped))
+		assert(t, !bar.Is(wrapped))
+
+		var pathErr *pathError
+		assert(t, errors.As(foo.Wrap(&pathError{}), &pathErr), "errors.As should reach a concrete type through a tagged wrapper")
+	})
+
+	t.Run("Stack Options", func(t *testing.T) {
+		SetCaptureStack(true)
+		SetStackDepth(64)
+
+		noStack := Tag("nostack").WithOptions(WithoutStack()).Wrap(fmt.Errorf("t"))
+		assert(t, StackTrace(noStack) == nil, "WithoutStack should suppress the stack")
+
+		withStack := Tag("stack").WithOptions(WithStackDepth(1)).Wrap(fmt.Errorf("t"))
+		assert(t, len(StackTrace(withStack)) >= 1, "WithStackDepth(1) should still capture a frame")
+
+		SetCaptureStack(false)
+		assert(t, StackTrace(Tag("x").Errorf("t")) == nil, "SetCaptureStack(false) should suppress the stack")
+		SetCaptureStack(true)
+	})
+
+	t.Run("Group", func(t *testing.T) {
+		err1 := foo.Errorf("one")
+		err2 := bar.Wrap(fmt.Errorf("two: %w", io.EOF))
+
+		combined := Combine(nil, err1, nil, err2)
+
+		assert(t, errors.Is(combined, io.EOF), "errors.Is should traverse into a Group branch")
+		assert(t, errors.Is(combined, foo), "errors.Is should find the foo tag in a Group branch")
+		assert(t, HasTag(combined, foo))
+		assert(t, HasTag(combined, bar))
+		assert(t, !HasTag(combined, baz))
+
+		assert(t,
+			strings.Count(fmt.Sprintf("%v", combined), "\n") == 1,
+			"%v should render one line per child",
+		)
+		assert(t,
+			strings.Contains(fmt.Sprintf("%+v", combined), "\n\t"),
+			"%+v should render a stack per child",
+		)
+
+		assert(t, Combine() == nil, "Combine of nothing should be nil")
+		assert(t, Combine(nil, nil) == nil, "Combine of only nils should be nil")
+		assert(t, Combine(err1) == err1, "Combine of one error should return it unwrapped")
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		t.Run("Round Trip", func(t *testing.T) {
+			orig := bar.Wrap(foo.Errorf("boom"))
+
+			data, err := Marshal(orig)
+			assert(t, err == nil, err)
+
+			got, err := Unmarshal(data)
+			assert(t, err == nil, err)
+
+			assert(t, HasTag(got, foo))
+			assert(t, HasTag(got, bar))
+			assert(t, strings.Contains(got.Error(), "boom"))
+			assert(t, len(StackTrace(orig)) > 0, "original error should have a stack")
+			assert(t,
+				strings.Contains(fmt.Sprintf("%+v", got), "\n\t"),
+				"reconstructed error should still render a stack",
+			)
+		})
+
+		t.Run("Shared Stack Not Duplicated", func(t *testing.T) {
+			// bar.Wrap(foo.Errorf(...)) re-tags the same error twice, so both
+			// layers share one captured stack; it should only hit the wire
+			// once.
+			orig := bar.Wrap(foo.Errorf("boom"))
+
+			data, err := Marshal(orig)
+			assert(t, err == nil, err)
+			assert(t,
+				strings.Count(string(data), `"stack":[`) == 1,
+				"a stack shared by re-tagging should be serialized once, got", string(data),
+			)
+
+			got, err := Unmarshal(data)
+			assert(t, err == nil, err)
+			assert(t,
+				strings.Contains(fmt.Sprintf("%+v", got), "\n\t"),
+				"reconstructed error should still render a stack despite the dedup",
+			)
+		})
+
+		t.Run("HTTP Status", func(t *testing.T) {
+			gone := Tag("json-gone").WithHTTPStatus(410)
+			data, err := Marshal(gone.Errorf("t"))
+			assert(t, err == nil, err)
+			assert(t, strings.Contains(string(data), `"http_status":410`), string(data))
+
+			// Simulate a receiving process that has no local registration for
+			// this tag: the status must still come back from the wire data.
+			delete(httpStatuses, Tag("json-gone"))
+
+			got, err := Unmarshal(data)
+			assert(t, err == nil, err)
+			assert(t, HTTPStatus(got) == 410, "HTTPStatus should survive the JSON round trip, got", HTTPStatus(got))
+		})
+
+		t.Run("Group", func(t *testing.T) {
+			combined := Combine(foo.Errorf("one"), bar.Errorf("two"))
+
+			data, err := Marshal(combined)
+			assert(t, err == nil, err)
+			assert(t, strings.Contains(string(data), `"children"`), string(data))
+
+			got, err := Unmarshal(data)
+			assert(t, err == nil, err)
+			assert(t, HasTag(got, foo), "Group round trip should preserve child tags")
+			assert(t, HasTag(got, bar), "Group round trip should preserve child tags")
+
+			group, ok := got.(*Group)
+			assert(t, ok, "Unmarshal of a combined error should produce a *Group")
+			assert(t, len(group.Errors()) == 2)
+		})
+
+		t.Run("Nil", func(t *testing.T) {
+			data, err := Marshal(nil)
+			assert(t, err == nil, err)
+			assert(t, string(data) == "null")
+
+			got, err := Unmarshal(data)
+			assert(t, err == nil, err)
+			assert(t, got == nil)
 		})
 	})
-}
\ No newline at end of file
+}